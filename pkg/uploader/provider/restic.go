@@ -17,10 +17,15 @@ limitations under the License.
 package provider
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -44,6 +49,25 @@ var resticRestoreCMDFunc = restic.RestoreCommand
 var resticTempCACertFileFunc = restic.TempCACertFile
 var resticCmdEnvFunc = restic.CmdEnv
 
+// resticExecCommandFunc stands in for exec.CommandContext, for the raw
+// restic invocations below that the restic.Command helpers above don't cover
+// (stdin/stdout piping, multi-repo args). Like the CMDFuncs above, it's a var
+// so tests can swap it out instead of shelling out to a real restic binary.
+var resticExecCommandFunc = exec.CommandContext
+
+// Compression modes supported by restic's `--compression` flag (restic >= 0.14).
+// These are only effective against repositories created with `--repository-version=2`.
+const (
+	resticCompressionOff  = "off"
+	resticCompressionAuto = "auto"
+	resticCompressionMax  = "max"
+
+	resticRepoConfigKey        = "resticRepoVersion"
+	resticCompressionConfigKey = "resticCompression"
+	resticRepoVersionV1        = "1"
+	resticRepoVersionV2        = "2"
+)
+
 type resticProvider struct {
 	repoIdentifier  string
 	credentialsFile string
@@ -52,6 +76,148 @@ type resticProvider struct {
 	extraFlags      []string
 	bsl             *velerov1api.BackupStorageLocation
 	log             logrus.FieldLogger
+
+	// compressionMode is the restic repository compression mode (off/auto/max)
+	// requested for this BSL/BackupRepository, as introduced by restic 0.14.
+	compressionMode string
+	// repoVersion is the restic repository format version this provider is
+	// talking to. It gates whether compressionMode may be anything other than "off".
+	repoVersion string
+}
+
+// compressionModeFromBSL reads the requested restic compression mode out of the
+// BSL's provider config, defaulting to "off" to preserve today's behavior.
+func compressionModeFromBSL(bsl *velerov1api.BackupStorageLocation) string {
+	if bsl == nil || bsl.Spec.Config == nil {
+		return resticCompressionOff
+	}
+	if mode, ok := bsl.Spec.Config[resticCompressionConfigKey]; ok && mode != "" {
+		return mode
+	}
+	return resticCompressionOff
+}
+
+// repoVersionFromBSL reads the restic repository format version out of the BSL's
+// provider config, defaulting to "1" since that's what existing repositories use.
+func repoVersionFromBSL(bsl *velerov1api.BackupStorageLocation) string {
+	if bsl == nil || bsl.Spec.Config == nil {
+		return resticRepoVersionV1
+	}
+	if version, ok := bsl.Spec.Config[resticRepoConfigKey]; ok && version != "" {
+		return version
+	}
+	return resticRepoVersionV1
+}
+
+// validateCompressionMode rejects compression modes that a v1 repository cannot
+// honor; restic only understands --compression against --repository-version=2.
+// An empty repoVersion is treated as "1", matching repoVersionFromBSL's default.
+func validateCompressionMode(mode, repoVersion string) error {
+	if mode == "" || mode == resticCompressionOff {
+		return nil
+	}
+	if mode != resticCompressionAuto && mode != resticCompressionMax {
+		return errors.Errorf("invalid restic compression mode %q, must be one of off/auto/max", mode)
+	}
+	if repoVersion == "" {
+		repoVersion = resticRepoVersionV1
+	}
+	if repoVersion != resticRepoVersionV2 {
+		return errors.Errorf("restic compression mode %q requires a v2 repository, but repository version is %q", mode, repoVersion)
+	}
+	return nil
+}
+
+// resticBackendSMB is the BSL provider name that routes the restic uploader
+// at an SMB/CIFS share, for on-prem clusters backing up to a Windows/Samba
+// share. Restic has no native SMB backend, so this is built on top of
+// restic's `rclone` backend instead: the repository identifier addresses an
+// rclone remote (rclone:<remote>:<path>), and that remote must already be
+// configured, of type "smb", in an rclone.conf provisioned out-of-band (e.g.
+// mounted into the pod) - Velero only supplies the remote's username and
+// password (plus the domain, when the BSL config sets one) at invocation
+// time, via rclone's per-remote environment variable
+// override convention (RCLONE_CONFIG_<REMOTE>_<OPTION>), from a Kubernetes
+// secret distinct from the repository's own encryption password.
+const resticBackendSMB = "smb"
+
+// BSL config keys understood for a `provider: smb` BackupStorageLocation.
+const (
+	// smbConfigRemote names the rclone remote (of type "smb") this BSL talks
+	// to; that remote's host/port must already be defined in an rclone.conf
+	// available to the restic process. Its domain comes from that file too,
+	// unless smbConfigDomain is set, which overrides it.
+	smbConfigRemote = "rcloneRemote"
+	smbConfigPath   = "path"
+	smbConfigDomain = "domain"
+)
+
+// SMBCredentials identifies the secret keys holding the SMB/CIFS share's own
+// login (username and password), which is a separate credential from the
+// repoKeySelector used to encrypt the restic repository itself.
+type SMBCredentials struct {
+	UserKeySelector     *v1.SecretKeySelector
+	PasswordKeySelector *v1.SecretKeySelector
+}
+
+// smbRepoIdentifier builds the rclone:<remote>:<path> repository URI
+// restic's rclone backend expects, from an smb BSL's provider config.
+func smbRepoIdentifier(bsl *velerov1api.BackupStorageLocation) (string, error) {
+	if bsl.Spec.Config == nil {
+		return "", errors.New("smb BSL is missing config")
+	}
+	remote := bsl.Spec.Config[smbConfigRemote]
+	if remote == "" {
+		return "", errors.Errorf("smb BSL config must set %q to the name of a pre-configured rclone smb remote", smbConfigRemote)
+	}
+
+	path := bsl.Spec.Config[smbConfigPath]
+	path = strings.TrimPrefix(path, "/")
+
+	return fmt.Sprintf("rclone:%s:%s", remote, path), nil
+}
+
+// rcloneRemoteEnvVar builds the RCLONE_CONFIG_<REMOTE>_<option> environment
+// variable name rclone recognizes as an override for option on remote, per
+// https://rclone.org/docs/#config-file - used here to supply the SMB
+// share's credentials without writing them into rclone.conf itself.
+func rcloneRemoteEnvVar(remote, option string) string {
+	return fmt.Sprintf("RCLONE_CONFIG_%s_%s", strings.ToUpper(remote), strings.ToUpper(option))
+}
+
+// smbCmdEnv builds the rclone remote credential overrides (and, if
+// configured, domain) restic's rclone backend needs on top of whatever
+// resticCmdEnvFunc already produced for the repository. shareUser and
+// sharePassword come from the BSL's SMBCredentials secret, not from the
+// repository's own encryption password.
+func smbCmdEnv(bsl *velerov1api.BackupStorageLocation, remote, shareUser, sharePassword string) []string {
+	env := []string{
+		fmt.Sprintf("%s=%s", rcloneRemoteEnvVar(remote, "user"), shareUser),
+		fmt.Sprintf("%s=%s", rcloneRemoteEnvVar(remote, "pass"), sharePassword),
+	}
+	if domain := bsl.Spec.Config[smbConfigDomain]; domain != "" {
+		env = append(env, fmt.Sprintf("%s=%s", rcloneRemoteEnvVar(remote, "domain"), domain))
+	}
+	return env
+}
+
+// readSecretKeyFile resolves selector to a value via fileStore, which writes
+// it out to a temp file (the only access FileStore offers); it reads that
+// file back into memory and removes it, since callers here need the value
+// itself (to export under a differently-named env var) rather than a path
+// restic can be pointed at directly.
+func readSecretKeyFile(fileStore credentials.FileStore, selector *v1.SecretKeySelector) (string, error) {
+	path, err := fileStore.Path(selector)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
 }
 
 func NewResticUploaderProvider(
@@ -59,6 +225,7 @@ func NewResticUploaderProvider(
 	bsl *velerov1api.BackupStorageLocation,
 	credGetter *credentials.CredentialGetter,
 	repoKeySelector *v1.SecretKeySelector,
+	smbCreds *SMBCredentials,
 	log logrus.FieldLogger,
 ) (Provider, error) {
 	provider := resticProvider{
@@ -73,6 +240,27 @@ func NewResticUploaderProvider(
 		return nil, errors.Wrap(err, "error creating temp restic credentials file")
 	}
 
+	var smbUser, smbPassword string
+	if bsl.Spec.Provider == resticBackendSMB {
+		if smbCreds == nil || smbCreds.UserKeySelector == nil || smbCreds.PasswordKeySelector == nil {
+			return nil, errors.New("smb BSL requires SMBCredentials with both a user and a password key selector")
+		}
+
+		smbUser, err = readSecretKeyFile(credGetter.FromFile, smbCreds.UserKeySelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading smb share username")
+		}
+		smbPassword, err = readSecretKeyFile(credGetter.FromFile, smbCreds.PasswordKeySelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading smb share password")
+		}
+
+		provider.repoIdentifier, err = smbRepoIdentifier(bsl)
+		if err != nil {
+			return nil, errors.Wrap(err, "error building smb repository identifier")
+		}
+	}
+
 	// if there's a caCert on the ObjectStorage, write it to disk so that it can be passed to restic
 	if bsl.Spec.ObjectStorage != nil && bsl.Spec.ObjectStorage.CACert != nil {
 		provider.caCertFile, err = resticTempCACertFileFunc(bsl.Spec.ObjectStorage.CACert, bsl.Name, filesystem.NewFileSystem())
@@ -86,6 +274,25 @@ func NewResticUploaderProvider(
 		return nil, errors.Wrap(err, "error generating repository cmnd env")
 	}
 
+	if bsl.Spec.Provider == resticBackendSMB {
+		provider.cmdEnv = append(provider.cmdEnv, smbCmdEnv(bsl, bsl.Spec.Config[smbConfigRemote], smbUser, smbPassword)...)
+	}
+
+	provider.repoVersion = repoVersionFromBSL(bsl)
+	provider.compressionMode = compressionModeFromBSL(bsl)
+	if err := validateCompressionMode(provider.compressionMode, provider.repoVersion); err != nil {
+		return nil, errors.Wrap(err, "error validating restic compression mode")
+	}
+	if provider.compressionMode != resticCompressionOff {
+		provider.cmdEnv = append(provider.cmdEnv, fmt.Sprintf("RESTIC_COMPRESSION=%s", provider.compressionMode))
+	}
+	// Note: --repository-version is only accepted by `restic init`, which this
+	// provider never calls (repository creation happens out-of-band before a
+	// BSL/BackupRepository reaches this code). repoVersion is only used above
+	// to validate that compressionMode is legal for the repo this provider was
+	// told it's talking to; it must never be added to extraFlags, since those
+	// are applied to every restic invocation this provider makes, not just init.
+
 	// #4820: restrieve insecureSkipTLSVerify from BSL configuration for
 	// AWS plugin. If nothing is return, that means insecureSkipTLSVerify
 	// is not enable for Restic command.
@@ -137,21 +344,24 @@ func (rp *resticProvider) RunBackup(
 		return "", false, errors.New("real source is not empty, this is not supported by restic uploader")
 	}
 
-	if volMode == uploader.PersistentVolumeBlock {
-		return "", false, errors.New("unable to support block mode")
-	}
-
 	log := rp.log.WithFields(logrus.Fields{
 		"path":           path,
 		"parentSnapshot": parentSnapshot,
 	})
 
+	if volMode == uploader.PersistentVolumeBlock {
+		return rp.runBlockBackup(ctx, path, tags, log, updater)
+	}
+
 	backupCmd := resticBackupCMDFunc(rp.repoIdentifier, rp.credentialsFile, path, tags)
 	backupCmd.Env = rp.cmdEnv
 	backupCmd.CACertFile = rp.caCertFile
 	if len(rp.extraFlags) != 0 {
 		backupCmd.ExtraFlags = append(backupCmd.ExtraFlags, rp.extraFlags...)
 	}
+	if rp.compressionMode != "" && rp.compressionMode != resticCompressionOff {
+		backupCmd.ExtraFlags = append(backupCmd.ExtraFlags, fmt.Sprintf("--compression=%s", rp.compressionMode))
+	}
 
 	if parentSnapshot != "" {
 		backupCmd.ExtraFlags = append(backupCmd.ExtraFlags, fmt.Sprintf("--parent=%s", parentSnapshot))
@@ -222,7 +432,7 @@ func (rp *resticProvider) RunRestore(
 	})
 
 	if volMode == uploader.PersistentVolumeBlock {
-		return errors.New("unable to support block mode")
+		return rp.runBlockRestore(ctx, snapshotID, volumePath, log, updater)
 	}
 
 	restoreCmd := resticRestoreCMDFunc(rp.repoIdentifier, rp.credentialsFile, snapshotID, volumePath)
@@ -236,3 +446,568 @@ func (rp *resticProvider) RunRestore(
 	log.Infof("Run command=%s, stdout=%s, stderr=%s", restoreCmd.Command, stdout, stderr)
 	return err
 }
+
+// blockModeTagKey marks a snapshot as having been produced by the block-mode
+// (raw device, --stdin) backup path rather than a normal directory backup.
+// RunRestore always receives volMode from its caller (derived from the
+// PVC's own volumeMode, which is known before the snapshot is looked up), so
+// restores are dispatched on that parameter rather than on this tag; the tag
+// exists purely so operators and tooling inspecting the repository (e.g.
+// `restic snapshots --tag velero.io/block-mode=true`) can tell block-mode
+// snapshots apart from regular ones.
+const blockModeTagKey = "velero.io/block-mode"
+
+// blockImageName derives the --stdin-filename restic records for a raw device
+// backup, so the corresponding `restic dump` on restore can address it back.
+func blockImageName(devicePath string) string {
+	return filepath.Base(devicePath) + ".img"
+}
+
+// blockProgress counts the bytes a blockProgressReader passes through while a
+// raw block device is streamed into restic's stdin mode (backup) or out of
+// `restic dump` (restore), and reports byte-level progress to updater, since
+// neither emits the per-file progress restic's JSON output normally provides.
+type blockProgress struct {
+	totalBytes int64
+	doneBytes  int64
+	updater    uploader.ProgressUpdater
+}
+
+func (p *blockProgress) add(n int) {
+	p.doneBytes += int64(n)
+	p.updater.UpdateProgress(&uploader.Progress{TotalBytes: p.totalBytes, BytesDone: p.doneBytes})
+}
+
+type blockProgressReader struct {
+	io.Reader
+	progress *blockProgress
+}
+
+func (r *blockProgressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.progress.add(n)
+	}
+	return n, err
+}
+
+// resticArgsForRepo builds the --repo/--password-file/cacert/extra-flags
+// arguments shared by every restic CLI invocation this provider makes outside
+// of the restic.Command helpers, which don't support piping stdin/stdout.
+func (rp *resticProvider) resticArgsForRepo() []string {
+	args := []string{"--repo", rp.repoIdentifier, "--password-file", rp.credentialsFile}
+	if rp.caCertFile != "" {
+		args = append(args, "--cacert", rp.caCertFile)
+	}
+	args = append(args, rp.extraFlags...)
+	return args
+}
+
+// runBlockBackup streams a raw block device (a PersistentVolumeBlock PVC's
+// device node, exposed into the data-mover pod at path) into restic via
+// `restic backup --stdin`, since restic can only walk directories otherwise.
+func (rp *resticProvider) runBlockBackup(
+	ctx context.Context,
+	path string,
+	tags map[string]string,
+	log logrus.FieldLogger,
+	updater uploader.ProgressUpdater) (string, bool, error) {
+	device, err := os.Open(path)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "error opening block device %s", path)
+	}
+	defer device.Close()
+
+	info, err := device.Stat()
+	if err != nil {
+		return "", false, errors.Wrapf(err, "error statting block device %s", path)
+	}
+
+	stdinFilename := blockImageName(path)
+	args := []string{"backup", "--stdin", "--stdin-filename", stdinFilename}
+	args = append(args, rp.resticArgsForRepo()...)
+	for k, v := range tags {
+		args = append(args, "--tag", fmt.Sprintf("%s=%s", k, v))
+	}
+	args = append(args, "--tag", fmt.Sprintf("%s=true", blockModeTagKey))
+	if rp.compressionMode != "" && rp.compressionMode != resticCompressionOff {
+		args = append(args, fmt.Sprintf("--compression=%s", rp.compressionMode))
+	}
+
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	cmd.Stdin = &blockProgressReader{
+		Reader:   device,
+		progress: &blockProgress{totalBytes: info.Size(), updater: updater},
+	}
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+
+	log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderrBuf.String(), "snapshot is empty") {
+			log.Debugf("Restic block backup got empty device %s", path)
+			return "", true, nil
+		}
+		return "", false, errors.WithStack(fmt.Errorf("error running restic block backup command with error: %v stderr: %v", err, stderrBuf.String()))
+	}
+
+	snapshotIDCmd := resticGetSnapshotFunc(rp.repoIdentifier, rp.credentialsFile, tags)
+	snapshotIDCmd.Env = rp.cmdEnv
+	snapshotIDCmd.CACertFile = rp.caCertFile
+	if len(rp.extraFlags) != 0 {
+		snapshotIDCmd.ExtraFlags = append(snapshotIDCmd.ExtraFlags, rp.extraFlags...)
+	}
+	snapshotID, err := resticGetSnapshotIDFunc(snapshotIDCmd)
+	if err != nil {
+		return "", false, errors.WithStack(fmt.Errorf("error getting snapshot id with error: %v", err))
+	}
+	log.Infof("restic block backup finished, snapshotID=%s, bytes=%d", snapshotID, info.Size())
+	return snapshotID, false, nil
+}
+
+// runBlockRestore pipes a previously block-mode-backed-up snapshot back onto
+// a raw device via `restic dump`, verifying the number of bytes written
+// against the size of the target device at volumePath.
+func (rp *resticProvider) runBlockRestore(
+	ctx context.Context,
+	snapshotID string,
+	volumePath string,
+	log logrus.FieldLogger,
+	updater uploader.ProgressUpdater) error {
+	device, err := os.OpenFile(volumePath, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrapf(err, "error opening block device %s", volumePath)
+	}
+	defer device.Close()
+
+	expectedSize, err := device.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "error determining size of block device %s", volumePath)
+	}
+	if _, err := device.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "error seeking block device %s", volumePath)
+	}
+
+	stdinFilename := blockImageName(volumePath)
+	args := []string{"dump", snapshotID, stdinFilename}
+	args = append(args, rp.resticArgsForRepo()...)
+
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "error opening restic dump stdout")
+	}
+
+	log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "error starting restic dump command")
+	}
+
+	progress := &blockProgress{totalBytes: expectedSize, updater: updater}
+	written, copyErr := io.Copy(device, &blockProgressReader{Reader: stdout, progress: progress})
+	waitErr := cmd.Wait()
+	if waitErr != nil {
+		return errors.WithStack(fmt.Errorf("error running restic dump command with error: %v stderr: %v", waitErr, stderrBuf.String()))
+	}
+	if copyErr != nil {
+		return errors.Wrap(copyErr, "error writing restic dump output to block device")
+	}
+	if written != expectedSize {
+		return errors.Errorf("restic dump wrote %d bytes, expected %d bytes for device %s", written, expectedSize, volumePath)
+	}
+	if err := device.Sync(); err != nil {
+		return errors.Wrapf(err, "error syncing block device %s", volumePath)
+	}
+
+	log.Infof("restic block restore finished, snapshotID=%s, bytes=%d", snapshotID, written)
+	return nil
+}
+
+// CheckRepoOptions configures a CheckRepo run.
+type CheckRepoOptions struct {
+	// ReadDataSubset is passed through to restic's `--read-data-subset`, e.g.
+	// "1/10" to read a tenth of all data packs, or "" to skip data reading
+	// and only verify the index/snapshot structure.
+	ReadDataSubset string
+}
+
+// CheckRepoResult summarizes the outcome of a CheckRepo run, so the calling
+// controller can decide whether to escalate to RepairRepo.
+//
+// Nothing in this package runs CheckRepo/RepairRepo on a schedule or records
+// their outcome as conditions on a BackupRepository; callers invoke them
+// directly.
+type CheckRepoResult struct {
+	PacksChecked int
+	PacksDamaged int
+	// DamagedPackIDs and MissingTreeSnapshots are best-effort, parsed out of
+	// restic's human-readable check output, for use as RepairRepo input.
+	DamagedPackIDs       []string
+	MissingTreeSnapshots []string
+	Clean                bool
+}
+
+// RepairRepoOptions selects which `restic repair` subcommands CheckRepo's
+// findings should be fed into.
+type RepairRepoOptions struct {
+	RepairIndex     bool
+	ForgetSnapshots bool
+	RepairPacks     bool
+	// PackIDs is required when RepairPacks is set; it's normally populated
+	// from a prior CheckRepoResult.DamagedPackIDs.
+	PackIDs []string
+}
+
+// RepairRepoResult summarizes the outcome of a RepairRepo run.
+type RepairRepoResult struct {
+	IndexRepaired   bool
+	SnapshotsForgot int
+	PacksRepaired   int
+}
+
+var (
+	damagedPackRE = regexp.MustCompile(`pack ([0-9a-f]+) is damaged`)
+	missingTreeRE = regexp.MustCompile(`snapshot ([0-9a-f]+) .*missing tree`)
+)
+
+// packIDRE matches a single pack ID as printed, one per line, by
+// `restic list packs`.
+var packIDRE = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// listRepoPacks runs `restic list packs` and returns the repository's full
+// set of pack IDs, so CheckRepo can report PacksChecked against a real
+// repository-provided count rather than a guess. `restic check` itself
+// doesn't print one line per pack it reads when run without a terminal
+// (its progress bar is a single, redrawn line), so this is the only way to
+// get real pack-level numbers out of a captured run.
+func (rp *resticProvider) listRepoPacks(ctx context.Context) ([]string, error) {
+	args := append([]string{"list", "packs"}, rp.resticArgsForRepo()...)
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	var outBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &stderrBuf
+
+	rp.log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("error running restic list packs command with error: %v stderr: %v", err, stderrBuf.String()))
+	}
+
+	var packIDs []string
+	for _, line := range strings.Split(outBuf.String(), "\n") {
+		if packIDRE.MatchString(line) {
+			packIDs = append(packIDs, line)
+		}
+	}
+	return packIDs, nil
+}
+
+// CheckRepo runs `restic check`, optionally reading back a subset of the
+// repository's data packs. Through updater it reports only the pack count
+// (as TotalBytes, then as BytesDone once the check has run); damaged packs
+// and snapshots with missing trees are reported only in the returned
+// CheckRepoResult, not through updater. PacksChecked comes from a `restic list packs`
+// call against the same repository, since restic check's own output gives
+// no reliable per-pack line to count when captured without a terminal.
+func (rp *resticProvider) CheckRepo(ctx context.Context, opts CheckRepoOptions, updater uploader.ProgressUpdater) (*CheckRepoResult, error) {
+	args := []string{"check"}
+	args = append(args, rp.resticArgsForRepo()...)
+	if opts.ReadDataSubset != "" {
+		args = append(args, fmt.Sprintf("--read-data-subset=%s", opts.ReadDataSubset))
+	}
+
+	log := rp.log.WithField("readDataSubset", opts.ReadDataSubset)
+
+	packIDs, err := rp.listRepoPacks(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing repository packs ahead of check")
+	}
+	if updater != nil {
+		updater.UpdateProgress(&uploader.Progress{TotalBytes: int64(len(packIDs))})
+	}
+
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	var outBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &outBuf
+
+	log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	runErr := cmd.Run()
+
+	result := &CheckRepoResult{PacksChecked: len(packIDs)}
+	for _, line := range strings.Split(outBuf.String(), "\n") {
+		if m := damagedPackRE.FindStringSubmatch(line); m != nil {
+			result.PacksDamaged++
+			result.DamagedPackIDs = append(result.DamagedPackIDs, m[1])
+		}
+		if m := missingTreeRE.FindStringSubmatch(line); m != nil {
+			result.MissingTreeSnapshots = append(result.MissingTreeSnapshots, m[1])
+		}
+	}
+	result.Clean = runErr == nil && result.PacksDamaged == 0 && len(result.MissingTreeSnapshots) == 0
+	if updater != nil {
+		updater.UpdateProgress(&uploader.Progress{TotalBytes: int64(len(packIDs)), BytesDone: int64(len(packIDs))})
+	}
+
+	if runErr != nil {
+		// restic check exits non-zero when it finds problems; since Clean is
+		// defined as runErr == nil && no damage found, a non-nil runErr
+		// always means !result.Clean, so that's expected here and reported
+		// via result rather than err.
+		log.Warnf("restic check reported problems: %v, output: %s", runErr, outBuf.String())
+		return result, nil
+	}
+	return result, nil
+}
+
+// RepairRepo drives `restic repair index`, `restic repair snapshots --forget`
+// and `restic repair packs` based on opts, typically populated from a
+// preceding CheckRepo call that found damage.
+func (rp *resticProvider) RepairRepo(ctx context.Context, opts RepairRepoOptions, updater uploader.ProgressUpdater) (*RepairRepoResult, error) {
+	result := &RepairRepoResult{}
+
+	if opts.RepairIndex {
+		if err := rp.runRepairSubcommand(ctx, []string{"repair", "index"}); err != nil {
+			return result, errors.Wrap(err, "error repairing restic index")
+		}
+		result.IndexRepaired = true
+	}
+
+	if opts.ForgetSnapshots {
+		if err := rp.runRepairSubcommand(ctx, []string{"repair", "snapshots", "--forget"}); err != nil {
+			return result, errors.Wrap(err, "error repairing restic snapshots")
+		}
+		result.SnapshotsForgot++
+	}
+
+	if opts.RepairPacks {
+		if len(opts.PackIDs) == 0 {
+			return result, errors.New("RepairPacks requested but no PackIDs provided")
+		}
+		args := append([]string{"repair", "packs"}, opts.PackIDs...)
+		if err := rp.runRepairSubcommand(ctx, args); err != nil {
+			return result, errors.Wrap(err, "error repairing restic packs")
+		}
+		result.PacksRepaired = len(opts.PackIDs)
+	}
+
+	if updater != nil {
+		updater.UpdateProgress(&uploader.Progress{TotalBytes: 1, BytesDone: 1})
+	}
+	return result, nil
+}
+
+func (rp *resticProvider) runRepairSubcommand(ctx context.Context, subArgs []string) error {
+	args := append(append([]string{}, subArgs...), rp.resticArgsForRepo()...)
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+
+	rp.log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return errors.WithStack(fmt.Errorf("error running restic repair command with error: %v stderr: %v", err, stderrBuf.String()))
+	}
+	return nil
+}
+
+// resticDestEnvSuffix is the suffix restic itself appends to its own
+// RESTIC_* environment variables to address the second ("repo2") repository
+// in a `restic copy`, e.g. RESTIC_PASSWORD becomes RESTIC_PASSWORD2. Restic
+// has no equivalent suffixed form for backend-specific credentials (e.g.
+// AWS_ACCESS_KEY_ID), so those are left out: copying between two
+// repositories of the same backend type that need different backend
+// credentials isn't supported through environment variables alone.
+const resticDestEnvSuffix = "2"
+
+// prefixDestEnv rewrites the generic RESTIC_* variables in env (as produced
+// by resticCmdEnvFunc for the destination repository) into the suffixed form
+// restic's `copy` command reads for its second repository, e.g.
+// RESTIC_PASSWORD becomes RESTIC_PASSWORD2. Non-RESTIC_ variables are
+// dropped; see resticDestEnvSuffix for why.
+func prefixDestEnv(env []string) []string {
+	suffixed := make([]string, 0, len(env))
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, val := parts[0], parts[1]
+		if !strings.HasPrefix(key, "RESTIC_") {
+			continue
+		}
+		suffixed = append(suffixed, fmt.Sprintf("%s%s=%s", key, resticDestEnvSuffix, val))
+	}
+	return suffixed
+}
+
+// CopySnapshotOptions identifies the secondary repository a snapshot should
+// be replicated into via `restic copy`.
+type CopySnapshotOptions struct {
+	SnapshotID          string
+	DestRepoIdentifier  string
+	DestBSL             *velerov1api.BackupStorageLocation
+	DestCredGetter      *credentials.CredentialGetter
+	DestRepoKeySelector *v1.SecretKeySelector
+}
+
+// CopySnapshot replicates a single snapshot from this provider's repository
+// into a second repository, identified by its own BSL and credentials
+// secret. The source repository is addressed the normal way, via --repo/
+// --password-file; the destination is what restic's `copy` command gives a
+// distinct --repo2/--password-file2 (and RESTIC_*2 environment) for.
+//
+// No custom resource or controller drives CopySnapshot; callers invoke it
+// directly.
+func (rp *resticProvider) CopySnapshot(ctx context.Context, opts CopySnapshotOptions, updater uploader.ProgressUpdater) (string, error) {
+	if opts.SnapshotID == "" {
+		return "", errors.New("snapshot ID is empty")
+	}
+
+	destCredFile, err := opts.DestCredGetter.FromFile.Path(opts.DestRepoKeySelector)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating temp restic credentials file for destination repository")
+	}
+	defer os.Remove(destCredFile)
+
+	destEnv, err := resticCmdEnvFunc(opts.DestBSL, opts.DestCredGetter.FromFile)
+	if err != nil {
+		return "", errors.Wrap(err, "error generating destination repository cmd env")
+	}
+
+	log := rp.log.WithFields(logrus.Fields{
+		"snapshotID": opts.SnapshotID,
+		"destRepo":   opts.DestRepoIdentifier,
+	})
+
+	args := []string{"copy", opts.SnapshotID, "--repo2", opts.DestRepoIdentifier, "--password-file2", destCredFile}
+	args = append(args, rp.resticArgsForRepo()...)
+
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append(append([]string{}, rp.cmdEnv...), prefixDestEnv(destEnv)...), os.Environ()...)
+
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "error opening restic copy stderr")
+	}
+
+	log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrap(err, "error starting restic copy command")
+	}
+
+	var stderrBuf bytes.Buffer
+	scanner := bufio.NewScanner(stderrPipe)
+	lines := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		stderrBuf.WriteString(line)
+		stderrBuf.WriteByte('\n')
+		lines++
+		if updater != nil {
+			updater.UpdateProgress(&uploader.Progress{TotalBytes: int64(lines), BytesDone: int64(lines)})
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", errors.WithStack(fmt.Errorf("error running restic copy command with error: %v stderr: %v", err, stderrBuf.String()))
+	}
+
+	log.Infof("restic copy finished, snapshotID=%s", opts.SnapshotID)
+	return opts.SnapshotID, nil
+}
+
+// RewriteSnapshotOptions configures a RewriteSnapshot run.
+type RewriteSnapshotOptions struct {
+	SnapshotID      string
+	ExcludePatterns []string
+	// DryRun previews the paths a rewrite would affect without committing it,
+	// so a caller can show what would be removed before confirming.
+	DryRun bool
+	// Prune runs `restic forget --prune` after a successful, non-dry-run
+	// rewrite to reclaim the space freed by the excluded data.
+	Prune bool
+}
+
+// RewriteSnapshotResult reports what a RewriteSnapshot run found or changed.
+type RewriteSnapshotResult struct {
+	// NewSnapshotID is empty for dry runs, since restic doesn't commit one.
+	NewSnapshotID string
+	AffectedPaths []string
+	DryRun        bool
+	Pruned        bool
+}
+
+var (
+	rewriteExcludingRE = regexp.MustCompile(`excluding (\S+)`)
+	rewriteNewSnapRE   = regexp.MustCompile(`saved new snapshot ([0-9a-f]+)`)
+)
+
+// RewriteSnapshot surgically removes paths matching excludePatterns from an
+// already-taken snapshot via `restic rewrite --forget`, without invalidating
+// unrelated data in the repository. In dry-run mode it parses restic's
+// summary output to preview the affected paths without committing anything.
+//
+// It works on one snapshot per call. Nothing in this package walks the
+// snapshots of a BackupRepository or selects them by namespace/PVC; a caller
+// that needs that calls RewriteSnapshot once per snapshot.
+func (rp *resticProvider) RewriteSnapshot(ctx context.Context, opts RewriteSnapshotOptions) (*RewriteSnapshotResult, error) {
+	if opts.SnapshotID == "" {
+		return nil, errors.New("snapshot ID is empty")
+	}
+	if len(opts.ExcludePatterns) == 0 {
+		return nil, errors.New("at least one exclude pattern is required")
+	}
+
+	// restic rewrite takes the snapshot ID as a trailing positional argument,
+	// not as a --snapshot flag.
+	args := []string{"rewrite", opts.SnapshotID, "--forget"}
+	for _, p := range opts.ExcludePatterns {
+		args = append(args, "--exclude", p)
+	}
+	if opts.DryRun {
+		args = append(args, "--dry-run")
+	}
+	args = append(args, rp.resticArgsForRepo()...)
+
+	log := rp.log.WithFields(logrus.Fields{
+		"snapshotID": opts.SnapshotID,
+		"dryRun":     opts.DryRun,
+	})
+
+	cmd := resticExecCommandFunc(ctx, "restic", args...)
+	cmd.Env = append(append([]string{}, rp.cmdEnv...), os.Environ()...)
+	var outBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &outBuf
+
+	log.Infof("Run command=%s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("error running restic rewrite command with error: %v output: %v", err, outBuf.String()))
+	}
+
+	result := &RewriteSnapshotResult{DryRun: opts.DryRun}
+	for _, m := range rewriteExcludingRE.FindAllStringSubmatch(outBuf.String(), -1) {
+		result.AffectedPaths = append(result.AffectedPaths, m[1])
+	}
+
+	if !opts.DryRun {
+		if m := rewriteNewSnapRE.FindStringSubmatch(outBuf.String()); m != nil {
+			result.NewSnapshotID = m[1]
+		}
+		if opts.Prune {
+			if err := rp.runRepairSubcommand(ctx, []string{"forget", "--prune"}); err != nil {
+				return result, errors.Wrap(err, "error running restic forget --prune after rewrite")
+			}
+			result.Pruned = true
+		}
+	}
+
+	log.Infof("restic rewrite finished, newSnapshotID=%s, affectedPaths=%d", result.NewSnapshotID, len(result.AffectedPaths))
+	return result, nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright The Velero Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/restic"
+	"github.com/vmware-tanzu/velero/pkg/uploader"
+)
+
+type noopProgressUpdater struct{}
+
+func (n *noopProgressUpdater) UpdateProgress(*uploader.Progress) {}
+
+func TestBlockImageName(t *testing.T) {
+	assert.Equal(t, "device0.img", blockImageName("/dev/block/device0"))
+}
+
+func TestResticArgsForRepo(t *testing.T) {
+	rp := &resticProvider{
+		repoIdentifier:  "s3:bucket/repo",
+		credentialsFile: "/tmp/creds",
+		caCertFile:      "/tmp/ca.pem",
+		extraFlags:      []string{"--insecure-tls"},
+	}
+	assert.Equal(t, []string{
+		"--repo", "s3:bucket/repo",
+		"--password-file", "/tmp/creds",
+		"--cacert", "/tmp/ca.pem",
+		"--insecure-tls",
+	}, rp.resticArgsForRepo())
+}
+
+func TestSMBRepoIdentifier(t *testing.T) {
+	bsl := &velerov1api.BackupStorageLocation{
+		Spec: velerov1api.BackupStorageLocationSpec{
+			Config: map[string]string{
+				smbConfigRemote: "backupsRemote",
+				smbConfigPath:   "velero",
+			},
+		},
+	}
+
+	repoID, err := smbRepoIdentifier(bsl)
+	require.NoError(t, err)
+	assert.Equal(t, "rclone:backupsRemote:velero", repoID)
+
+	_, err = smbRepoIdentifier(&velerov1api.BackupStorageLocation{})
+	assert.Error(t, err)
+}
+
+func TestSMBCmdEnvUsesShareCredentialsNotRepoPassword(t *testing.T) {
+	bsl := &velerov1api.BackupStorageLocation{
+		Spec: velerov1api.BackupStorageLocationSpec{
+			Config: map[string]string{smbConfigDomain: "CORP"},
+		},
+	}
+	env := smbCmdEnv(bsl, "backupsRemote", "backup-svc", "share-secret")
+	assert.Contains(t, env, "RCLONE_CONFIG_BACKUPSREMOTE_USER=backup-svc")
+	assert.Contains(t, env, "RCLONE_CONFIG_BACKUPSREMOTE_PASS=share-secret")
+	assert.Contains(t, env, "RCLONE_CONFIG_BACKUPSREMOTE_DOMAIN=CORP")
+	assert.NotContains(t, env, "RESTIC_PASSWORD=share-secret")
+}
+
+func TestPrefixDestEnv(t *testing.T) {
+	in := []string{
+		"RESTIC_PASSWORD=hunter2",
+		"RESTIC_REPOSITORY=s3:bucket/repo",
+		"AWS_ACCESS_KEY_ID=AKIA...",
+		"malformed",
+	}
+	assert.ElementsMatch(t, []string{
+		"RESTIC_PASSWORD2=hunter2",
+		"RESTIC_REPOSITORY2=s3:bucket/repo",
+	}, prefixDestEnv(in))
+}
+
+func TestCheckRepoParsesOutput(t *testing.T) {
+	origExec := resticExecCommandFunc
+	defer func() { resticExecCommandFunc = origExec }()
+
+	packIDs := strings.Repeat("a", 64) + "\n" + strings.Repeat("b", 64) + "\n"
+	checkOutput := "no errors were found\n" +
+		"pack abc123 is damaged\n" +
+		"snapshot def456 of <Snapshot> at 2023-01-01 01:01:01 is missing tree\n"
+	resticExecCommandFunc = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		if args[0] == "list" {
+			return exec.CommandContext(ctx, "printf", "%s", packIDs)
+		}
+		return exec.CommandContext(ctx, "printf", "%s", checkOutput)
+	}
+
+	rp := &resticProvider{repoIdentifier: "s3:bucket/repo", credentialsFile: "/tmp/creds", log: logrus.New()}
+	result, err := rp.CheckRepo(context.Background(), CheckRepoOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.PacksChecked)
+	assert.Equal(t, 1, result.PacksDamaged)
+	assert.Equal(t, []string{"abc123"}, result.DamagedPackIDs)
+	assert.Equal(t, []string{"def456"}, result.MissingTreeSnapshots)
+	assert.False(t, result.Clean)
+}
+
+func TestRewriteSnapshotPassesSnapshotIDPositionally(t *testing.T) {
+	origExec := resticExecCommandFunc
+	defer func() { resticExecCommandFunc = origExec }()
+
+	output := "excluding /data/secret.txt\nsaved new snapshot abc123\n"
+	var capturedArgs []string
+	resticExecCommandFunc = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		capturedArgs = args
+		return exec.CommandContext(ctx, "printf", "%s", output)
+	}
+
+	rp := &resticProvider{repoIdentifier: "s3:bucket/repo", credentialsFile: "/tmp/creds", log: logrus.New()}
+	result, err := rp.RewriteSnapshot(context.Background(), RewriteSnapshotOptions{
+		SnapshotID:      "deadbeef",
+		ExcludePatterns: []string{"/data/secret.txt"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", result.NewSnapshotID)
+	assert.Equal(t, []string{"/data/secret.txt"}, result.AffectedPaths)
+
+	require.GreaterOrEqual(t, len(capturedArgs), 2)
+	assert.Equal(t, "rewrite", capturedArgs[0])
+	assert.Equal(t, "deadbeef", capturedArgs[1])
+	assert.NotContains(t, capturedArgs, "--snapshot")
+}
+
+// TestRunBlockBackupUsesInjectableExec verifies runBlockBackup runs restic
+// through the resticExecCommandFunc seam rather than shelling out directly,
+// so it can be driven here without a real restic binary.
+func TestRunBlockBackupUsesInjectableExec(t *testing.T) {
+	origExec := resticExecCommandFunc
+	origGetSnapshotID := resticGetSnapshotIDFunc
+	defer func() {
+		resticExecCommandFunc = origExec
+		resticGetSnapshotIDFunc = origGetSnapshotID
+	}()
+
+	var capturedName string
+	var capturedArgs []string
+	resticExecCommandFunc = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		capturedName = name
+		capturedArgs = args
+		return exec.CommandContext(ctx, "true")
+	}
+	resticGetSnapshotIDFunc = func(snapshotIDCmd restic.Command) (string, error) {
+		return "deadbeef", nil
+	}
+
+	device, err := os.CreateTemp(t.TempDir(), "block-device")
+	require.NoError(t, err)
+	_, err = device.WriteString("hello")
+	require.NoError(t, err)
+	require.NoError(t, device.Close())
+
+	rp := &resticProvider{
+		repoIdentifier:  "s3:bucket/repo",
+		credentialsFile: "/tmp/creds",
+		log:             logrus.New(),
+	}
+
+	snapshotID, isEmpty, err := rp.runBlockBackup(context.Background(), device.Name(), map[string]string{"a": "b"}, rp.log, &noopProgressUpdater{})
+	require.NoError(t, err)
+	assert.False(t, isEmpty)
+	assert.Equal(t, "deadbeef", snapshotID)
+	assert.Equal(t, "restic", capturedName)
+	assert.Contains(t, capturedArgs, "--stdin")
+	assert.Contains(t, capturedArgs, "a=b")
+}
+
+func TestRepoVersionFromBSL(t *testing.T) {
+	tests := []struct {
+		name     string
+		bsl      *velerov1api.BackupStorageLocation
+		expected string
+	}{
+		{
+			name:     "nil BSL defaults to v1",
+			bsl:      nil,
+			expected: resticRepoVersionV1,
+		},
+		{
+			name:     "nil config defaults to v1",
+			bsl:      &velerov1api.BackupStorageLocation{},
+			expected: resticRepoVersionV1,
+		},
+		{
+			name: "missing key defaults to v1",
+			bsl: &velerov1api.BackupStorageLocation{
+				Spec: velerov1api.BackupStorageLocationSpec{Config: map[string]string{}},
+			},
+			expected: resticRepoVersionV1,
+		},
+		{
+			name: "explicit v2",
+			bsl: &velerov1api.BackupStorageLocation{
+				Spec: velerov1api.BackupStorageLocationSpec{Config: map[string]string{resticRepoConfigKey: "2"}},
+			},
+			expected: "2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, repoVersionFromBSL(test.bsl))
+		})
+	}
+}
+
+func TestValidateCompressionMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		repoVersion string
+		expectErr   bool
+	}{
+		{name: "off is always fine", mode: resticCompressionOff, repoVersion: "1", expectErr: false},
+		{name: "empty mode is always fine", mode: "", repoVersion: "", expectErr: false},
+		{name: "invalid mode", mode: "ultra", repoVersion: "2", expectErr: true},
+		{name: "auto against v2", mode: resticCompressionAuto, repoVersion: resticRepoVersionV2, expectErr: false},
+		{name: "max against v2", mode: resticCompressionMax, repoVersion: resticRepoVersionV2, expectErr: false},
+		{name: "auto against v1", mode: resticCompressionAuto, repoVersion: resticRepoVersionV1, expectErr: true},
+		{name: "auto against empty repoVersion is treated as v1", mode: resticCompressionAuto, repoVersion: "", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCompressionMode(test.mode, test.repoVersion)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}